@@ -1,7 +1,12 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -12,8 +17,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
@@ -23,6 +33,7 @@ type FirmwareVersion struct {
 	Date     string `json:"date"`
 	Download string `json:"download"`
 	Version  string `json:"version"`
+	Sha256   string `json:"sha256,omitempty"`
 }
 
 type Firmware struct {
@@ -52,30 +63,444 @@ const (
 	overridesFile                = "overrides.yaml"
 	buildDirectory               = "build"
 	buildBinDirectory            = "build/bin"
-	buildOutDirectory            = "build/out"
-	buildSrcDirectory            = "build/src"
-	buildYamlFile                = "build/kobopatch.yaml"
+	buildReleaseDirectory        = "build/release"
 )
 
-var (
-	version = flag.String("version", "4.19.14123", "version of the patch to create")
-	uuid    = flag.String("uuid", "00000000-0000-0000-0000-000000000370", "uuid of the kobo (see firmwares.json)")
-)
+// BuildContext carries the per-invocation parameters that used to be
+// package-level flag globals, threaded explicitly through downloadFirmware,
+// updateKobopatchYaml, buildKobopatch and patchFirmware as a receiver. This
+// is what lets each subcommand build its own context from its own flag set
+// instead of every step reaching for the same global state.
+type BuildContext struct {
+	Version string
+	UUID    string
+
+	Dirs struct {
+		Build string
+		Bin   string
+		Out   string
+		Src   string
+		Yaml  string
+	}
+
+	Logger     *log.Logger
+	HTTPClient *http.Client
+}
+
+// newBuildContext lays out the isolated build/<version>/{src,out,kobopatch.yaml}
+// paths for version, alongside the shared build/bin directory that every
+// version's build reuses.
+func newBuildContext(version, uuid string) *BuildContext {
+	ctx := &BuildContext{
+		Version:    version,
+		UUID:       uuid,
+		Logger:     log.New(os.Stdout, "", 0),
+		HTTPClient: http.DefaultClient,
+	}
+
+	build := filepath.Join(buildDirectory, version)
+	ctx.Dirs.Build = build
+	ctx.Dirs.Bin = buildBinDirectory
+	ctx.Dirs.Src = filepath.Join(build, "src")
+	ctx.Dirs.Out = filepath.Join(build, "out")
+	ctx.Dirs.Yaml = filepath.Join(build, "kobopatch.yaml")
+
+	return ctx
+}
+
+// BuildOptions selects which targets buildKobopatch cross-compiles for.
+type BuildOptions struct {
+	Targets  string
+	Include  string
+	Exclude  string
+	Parallel int
+}
+
+// Target describes a single cross-compilation target: the GOOS/GOARCH/GOARM
+// triple passed to the child `go build`, optional build tags, and the
+// suffix/extension used to name the resulting binary.
+type Target struct {
+	GOOS   string
+	GOARCH string
+	GOARM  string
+	Tags   string
+	Suffix string
+	Ext    string
+}
+
+func (t Target) String() string {
+	return fmt.Sprintf("%s/%s", t.GOOS, t.GOARCH)
+}
+
+// allTargets is the full matrix of platforms kobopatch tools are built for.
+// Note: the old windows/386 build passed `-ldflags "-extldflags -static"`;
+// that's no longer needed since every target below now builds with
+// CGO_ENABLED=0, which produces a static binary regardless.
+var allTargets = []Target{
+	{GOOS: "linux", GOARCH: "amd64", Suffix: "linux-64bit"},
+	{GOOS: "linux", GOARCH: "386", Suffix: "linux-32bit"},
+	{GOOS: "darwin", GOARCH: "amd64", Suffix: "darwin-64bit"},
+	{GOOS: "windows", GOARCH: "386", Suffix: "windows", Ext: ".exe"},
+}
+
+// binaryNameTemplate renders the output filename for a built package, e.g.
+// "kobopatch-linux-64bit" or "cssextract-windows.exe".
+var binaryNameTemplate = template.Must(template.New("binaryName").Parse("{{.Name}}-{{.Target.Suffix}}{{.Target.Ext}}"))
+
+// packages lists the kobopatch binaries built from this repo.
+var packages = []struct {
+	Path string
+	Name string
+}{
+	{Path: "kobopatch", Name: "kobopatch"},
+	{Path: "tools/cssextract", Name: "cssextract"},
+	{Path: "tools/kobopatch-apply", Name: "kobopatch-apply"},
+}
+
+// selectTargets narrows allTargets down to the ones requested via opts. With
+// Targets/Include/Exclude all empty it defaults to the host's own os/arch,
+// since that's all patchFirmware needs to run the patcher locally.
+func selectTargets(opts BuildOptions) ([]Target, error) {
+	selected := allTargets
+
+	if opts.Targets != "" {
+		wanted := make(map[string]bool)
+		for _, t := range strings.Split(opts.Targets, ",") {
+			wanted[strings.TrimSpace(t)] = true
+		}
+
+		var filtered []Target
+		for _, t := range selected {
+			if wanted[t.String()] {
+				filtered = append(filtered, t)
+			}
+		}
+		selected = filtered
+	} else if opts.Include == "" && opts.Exclude == "" {
+		host := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+		var filtered []Target
+		for _, t := range selected {
+			if t.String() == host {
+				filtered = append(filtered, t)
+			}
+		}
+		selected = filtered
+	}
+
+	if opts.Include != "" {
+		re, err := regexp.Compile(opts.Include)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -include pattern")
+		}
+
+		var filtered []Target
+		for _, t := range selected {
+			if re.MatchString(t.String()) {
+				filtered = append(filtered, t)
+			}
+		}
+		selected = filtered
+	}
+
+	if opts.Exclude != "" {
+		re, err := regexp.Compile(opts.Exclude)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid -exclude pattern")
+		}
+
+		var filtered []Target
+		for _, t := range selected {
+			if !re.MatchString(t.String()) {
+				filtered = append(filtered, t)
+			}
+		}
+		selected = filtered
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.New("no targets matched -targets/-include/-exclude")
+	}
+
+	return selected, nil
+}
+
+// versionPredicate is a parsed `-version` selector used with -all: either a
+// comparison against a reference version (">=4.19.14123") or a dot-segment
+// glob ("4.3*.*"), falling back to an exact match when neither applies.
+type versionPredicate struct {
+	op      string
+	pattern string
+}
+
+func parseVersionPredicate(s string) versionPredicate {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			return versionPredicate{op: op, pattern: strings.TrimPrefix(s, op)}
+		}
+	}
+
+	return versionPredicate{pattern: s}
+}
+
+func (p versionPredicate) Match(v string) (bool, error) {
+	if p.op == "" {
+		if !strings.Contains(p.pattern, "*") {
+			return p.pattern == v, nil
+		}
+
+		re := "^" + strings.Replace(regexp.QuoteMeta(p.pattern), `\*`, `[^.]*`, -1) + "$"
+		return regexp.MatchString(re, v)
+	}
+
+	cmp := compareVersions(v, p.pattern)
+	switch p.op {
+	case ">=":
+		return cmp >= 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "=":
+		return cmp == 0, nil
+	}
+
+	return false, errors.Errorf("unknown version operator %q", p.op)
+}
+
+// compareVersions compares two dot-separated version strings segment by
+// segment, numerically where both segments parse as integers and lexically
+// otherwise. It returns -1, 0 or 1, mirroring strings.Compare.
+func compareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var as_, bs_ string
+		if i < len(as) {
+			as_ = as[i]
+		}
+		if i < len(bs) {
+			bs_ = bs[i]
+		}
+
+		an, aerr := strconv.Atoi(as_)
+		bn, berr := strconv.Atoi(bs_)
+		if aerr == nil && berr == nil {
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if as_ != bs_ {
+			return strings.Compare(as_, bs_)
+		}
+	}
+
+	return 0
+}
+
+// firmwareCache stores downloaded firmware zips under the user's cache
+// directory, keyed by (uuid, version), so that repeated clean builds don't
+// re-download hundreds of MB of firmware images.
+type firmwareCache struct {
+	dir string
+}
+
+func newFirmwareCache() (*firmwareCache, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(base, "kobo", "firmware")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return &firmwareCache{dir: dir}, nil
+}
+
+func (c *firmwareCache) path(uuid, version string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%s-%s.zip", uuid, version))
+}
+
+// Fetch returns the path to v's firmware zip in the cache, downloading (or
+// resuming) it first if it's missing, stale (refresh) or fails its recorded
+// checksum.
+func (c *firmwareCache) Fetch(client *http.Client, logger *log.Logger, uuid string, v FirmwareVersion, refresh bool) (string, error) {
+	final := c.path(uuid, v.Version)
+
+	if refresh {
+		// A user asking to refresh wants a clean re-fetch, not a resume of
+		// whatever partial/stale bytes happen to be sitting in the cache.
+		if err := os.Remove(final + ".part"); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	if !refresh {
+		if _, err := os.Stat(final); err == nil {
+			if v.Sha256 == "" {
+				logger.Println("Already cached: " + final)
+				return final, nil
+			}
+
+			ok, err := verifySha256(final, v.Sha256)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				logger.Println("Already cached: " + final)
+				return final, nil
+			}
+
+			logger.Println("Cached file failed checksum, re-downloading: " + final)
+		}
+	}
+
+	if err := c.download(client, logger, final, v); err != nil {
+		return "", err
+	}
+
+	return final, nil
+}
+
+// download fetches v.Download into final, resuming from a `.part` file via
+// an HTTP Range request if one already exists, and verifies v.Sha256 (when
+// set) before atomically renaming it into place.
+func (c *firmwareCache) download(client *http.Client, logger *log.Logger, final string, v FirmwareVersion) error {
+	part := final + ".part"
+
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest("GET", v.Download, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return errors.Errorf("downloading %s: unexpected status %s", v.Download, resp.Status)
+	}
+
+	hasher := sha256.New()
+	flags := os.O_CREATE | os.O_WRONLY
+
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		logger.Println("Resuming download: " + v.Download)
+		flags |= os.O_APPEND
+
+		existing, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(hasher, existing)
+		existing.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		logger.Println("Downloading: " + v.Download)
+		flags |= os.O_TRUNC
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, io.TeeReader(resp.Body, hasher))
+	closeErr := out.Close()
+	if err != nil {
+		return err
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if v.Sha256 != "" {
+		sum := hex.EncodeToString(hasher.Sum(nil))
+		if sum != v.Sha256 {
+			// Drop the .part so the next attempt does a clean re-download
+			// instead of resuming from (and re-hashing) these bad bytes.
+			if rmErr := os.Remove(part); rmErr != nil && !os.IsNotExist(rmErr) {
+				return rmErr
+			}
+			return errors.Errorf("checksum mismatch for %s: got %s, want %s", v.Download, sum, v.Sha256)
+		}
+	}
+
+	logger.Println("Downloaded: " + final)
+	return os.Rename(part, final)
+}
+
+func verifySha256(path, want string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == want, nil
+}
+
+// linkOrCopy makes the cached firmware zip available at dst (inside a
+// version's build/src directory), symlinking where possible and falling
+// back to a copy so the rest of the pipeline doesn't need to know about
+// the cache at all.
+func linkOrCopy(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	if err := os.Symlink(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
 
-func init() {
-	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n\nOptions:\n", os.Args[0])
-		flag.PrintDefaults()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
 	}
+	defer out.Close()
 
-	flag.Parse()
+	_, err = io.Copy(out, in)
+	return err
 }
 
-func downloadFirmware(url string) (string, error) {
-	outfile := filepath.Join(buildSrcDirectory, fmt.Sprintf("kobo-update-%s.zip", *version))
+// downloadFirmwareDirect bypasses the cache entirely (-no-cache), downloading
+// straight into outfile as the original naive implementation did.
+func downloadFirmwareDirect(client *http.Client, logger *log.Logger, url, outfile string) (string, error) {
 	if _, err := os.Stat(outfile); os.IsNotExist(err) {
-		fmt.Println("Downloading: " + url)
-		resp, err := http.Get(url)
+		logger.Println("Downloading: " + url)
+		resp, err := client.Get(url)
 		if err != nil {
 			return "", err
 		}
@@ -92,9 +517,36 @@ func downloadFirmware(url string) (string, error) {
 			return "", err
 		}
 
-		fmt.Println("Downloaded: " + outfile)
+		logger.Println("Downloaded: " + outfile)
 	} else {
-		fmt.Println("Already exists: " + outfile)
+		logger.Println("Already exists: " + outfile)
+	}
+
+	return outfile, nil
+}
+
+// downloadFirmware makes v's firmware zip available at
+// ctx.Dirs.Src/kobo-update-<version>.zip, going through the firmware cache
+// unless noCache is set.
+func (ctx *BuildContext) downloadFirmware(v FirmwareVersion, noCache, refresh bool) (string, error) {
+	outfile := filepath.Join(ctx.Dirs.Src, fmt.Sprintf("kobo-update-%s.zip", v.Version))
+
+	if noCache {
+		return downloadFirmwareDirect(ctx.HTTPClient, ctx.Logger, v.Download, outfile)
+	}
+
+	cache, err := newFirmwareCache()
+	if err != nil {
+		return "", err
+	}
+
+	cached, err := cache.Fetch(ctx.HTTPClient, ctx.Logger, ctx.UUID, v, refresh)
+	if err != nil {
+		return "", err
+	}
+
+	if err := linkOrCopy(cached, outfile); err != nil {
+		return "", err
 	}
 
 	return outfile, nil
@@ -122,14 +574,14 @@ func appendFileToFile(a, b string) error {
 	return err
 }
 
-func updateKobopatchYaml() error {
+func (ctx *BuildContext) updateKobopatchYaml(v FirmwareVersion) error {
 	kobopatchYamlFile, err := ioutil.ReadFile(kobopatchPatchesSrcYamlFile)
 	if err != nil {
 		return errors.Wrap(err, "failed to read: "+kobopatchPatchesSrcYamlFile)
 	}
 
 	// Replace `{{version}}` strings in `kobopatch.yaml` with version otherwise yaml parsing fails.
-	kobopatchYamlFile = bytes.Replace(kobopatchYamlFile, []byte("{{version}}"), []byte(*version), -1)
+	kobopatchYamlFile = bytes.Replace(kobopatchYamlFile, []byte("{{version}}"), []byte(v.Version), -1)
 
 	var kobopatchYaml KobopatchYaml
 	err = yaml.Unmarshal(kobopatchYamlFile, &kobopatchYaml)
@@ -149,8 +601,8 @@ func updateKobopatchYaml() error {
 	}
 
 	// Override the version and in files with the passed version.
-	kobopatchYaml.Version = *version
-	kobopatchYaml.In = fmt.Sprintf("src/kobo-update-%s.zip", *version)
+	kobopatchYaml.Version = v.Version
+	kobopatchYaml.In = fmt.Sprintf("src/kobo-update-%s.zip", v.Version)
 
 	// Write out a new `kobopatch.yaml` file with the version and overrides applied.
 	kobopatchYamlUpdated, err := yaml.Marshal(kobopatchYaml)
@@ -158,7 +610,7 @@ func updateKobopatchYaml() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(buildYamlFile, kobopatchYamlUpdated, 0644)
+	err = ioutil.WriteFile(ctx.Dirs.Yaml, kobopatchYamlUpdated, 0644)
 	if err != nil {
 		return err
 	}
@@ -166,83 +618,157 @@ func updateKobopatchYaml() error {
 	return nil
 }
 
-func buildKobopatch() (map[string]string, error) {
-	buildPackage := func(pkgPath, outfile string, extraArgs []string) error {
-		pkgPath, err := filepath.Rel(kobopatchDirectory, filepath.Join(kobopatchDirectory, pkgPath))
-		if err != nil {
-			return err
-		}
+// buildPackage cross-compiles a single package for a single target, returning
+// the binary's filename (relative to binDir).
+func buildPackage(logger *log.Logger, binDir string, t Target, pkg struct {
+	Path string
+	Name string
+}) (string, error) {
+	var nameBuf bytes.Buffer
+	err := binaryNameTemplate.Execute(&nameBuf, struct {
+		Name   string
+		Target Target
+	}{Name: pkg.Name, Target: t})
+	if err != nil {
+		return "", err
+	}
+	outfile := nameBuf.String()
 
-		outfile, err = filepath.Rel(kobopatchDirectory, filepath.Join(buildBinDirectory, outfile))
-		if err != nil {
-			return err
+	pkgPath, err := filepath.Rel(kobopatchDirectory, filepath.Join(kobopatchDirectory, pkg.Path))
+	if err != nil {
+		return "", err
+	}
+
+	outRel, err := filepath.Rel(kobopatchDirectory, filepath.Join(binDir, outfile))
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"build"}
+	if t.Tags != "" {
+		args = append(args, "-tags", t.Tags)
+	}
+	args = append(args, fmt.Sprintf("-o=%s", outRel), fmt.Sprintf("./%s", pkgPath))
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = kobopatchDirectory
+	cmd.Env = append(os.Environ(), "GOOS="+t.GOOS, "GOARCH="+t.GOARCH, "CGO_ENABLED=0")
+	if t.GOARM != "" {
+		cmd.Env = append(cmd.Env, "GOARM="+t.GOARM)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logger.Printf("[%s] go %s\n", t.String(), strings.Join(args, " "))
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, output.String())
+	}
+
+	return outfile, nil
+}
+
+// buildKobopatch cross-compiles the kobopatch tools for every target
+// selected via opts, running up to opts.Parallel builds concurrently. It
+// returns the produced binary names keyed by target (os/arch) and then
+// package name. The first build failure is returned once all in-flight
+// builds have drained; no new builds are started afterwards.
+func (ctx *BuildContext) buildKobopatch(opts BuildOptions) (map[string]map[string]string, error) {
+	targets, err := selectTargets(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	type job struct {
+		target Target
+		pkg    struct {
+			Path string
+			Name string
 		}
+	}
 
-		args := []string{fmt.Sprintf("-o=%s", outfile), fmt.Sprintf("./%s", pkgPath)}
-		if extraArgs != nil {
-			args = append(args, extraArgs...)
+	var jobs []job
+	for _, t := range targets {
+		for _, p := range packages {
+			jobs = append(jobs, job{target: t, pkg: p})
 		}
-		args = append([]string{"build"}, args...)
-		fmt.Println(fmt.Sprintf("go %s", strings.Join(args, " ")))
+	}
 
-		cmd := exec.Command("go", args...)
-		cmd.Dir = kobopatchDirectory
+	results := make(map[string]map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	stop := make(chan struct{})
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
 
-		if err := cmd.Run(); err != nil {
-			return err
+	for _, j := range jobs {
+		select {
+		case <-stop:
+			continue
+		default:
 		}
 
-		return nil
+		j := j
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			select {
+			case <-stop:
+				return
+			default:
+			}
+
+			outfile, err := buildPackage(ctx.Logger, ctx.Dirs.Bin, j.target, j.pkg)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = errors.Wrapf(err, "failed to build %s for %s", j.pkg.Name, j.target.String())
+					close(stop)
+				})
+				return
+			}
+
+			if results[j.target.String()] == nil {
+				results[j.target.String()] = make(map[string]string)
+			}
+			results[j.target.String()][j.pkg.Name] = outfile
+		}()
 	}
+	wg.Wait()
 
-	var extraArgs []string
-	buildMap := make(map[string]string)
-
-	switch fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH) {
-	case "linux/amd64":
-		buildMap["kobopatch"] = "kobopatch-linux-64bit"
-		buildMap["tools/cssextract"] = "kobopatch-apply-linux-64bit"
-		buildMap["tools/kobopatch-apply"] = "cssextract-linux-64bit"
-	case "linux/386":
-		buildMap["kobopatch"] = "kobopatch-linux-32bit"
-		buildMap["tools/cssextract"] = "kobopatch-apply-linux-32bit"
-		buildMap["tools/kobopatch-apply"] = "cssextract-linux-32bit"
-	case "darwin/amd64":
-		buildMap["kobopatch"] = "kobopatch-darwin-64bit"
-		buildMap["tools/cssextract"] = "cssextract-darwin-64bit"
-		buildMap["tools/kobopatch-apply"] = "kobopatch-apply-darwin-64bit"
-	case "windows/386":
-		extraArgs = []string{"-ldflags \"-extldflags -static\""}
-		buildMap["kobopatch"] = "koboptch-windows.exe"
-		buildMap["tools/cssextract"] = "cssextract-windows.exe"
-		buildMap["tools/kobopatch-apply"] = "koboptch-apply-windows.exe"
-	}
-
-	for pkg, out := range buildMap {
-		err := buildPackage(pkg, out, extraArgs)
-		if err != nil {
-			return nil, err
-		}
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
-	return buildMap, nil
+	return results, nil
 }
 
-func patchFirmware(v FirmwareVersion) error {
-	// Build kobopatch and place in kobopatchPatchesBinDirectory
-	buildMap, err := buildKobopatch()
-	if err != nil {
-		return err
-	}
-
+// patchFirmware downloads v, concatenates its patches and runs the already
+// cross-compiled kobopatch binary against the result. buildMap is expected
+// to have been built once (e.g. via buildKobopatch) and shared across every
+// version in a batch, rather than rebuilt per call.
+func (ctx *BuildContext) patchFirmware(v FirmwareVersion, buildMap map[string]map[string]string, noCache, refresh bool) error {
 	// Download the firmware first.
-	_, err = downloadFirmware(v.Download)
+	_, err := ctx.downloadFirmware(v, noCache, refresh)
 	if err != nil {
 		return err
 	}
 
 	// Remove any pre-built yaml files.
-	err = filepath.Walk(buildSrcDirectory, func(path string, f os.FileInfo, err error) error {
+	err = filepath.Walk(ctx.Dirs.Src, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -261,14 +787,14 @@ func patchFirmware(v FirmwareVersion) error {
 	}
 
 	// Concat all patches together.
-	patchfilesDirectory := fmt.Sprintf("kobopatch-patches/src/versions/%s", *version)
+	patchfilesDirectory := fmt.Sprintf("kobopatch-patches/src/versions/%s", v.Version)
 	err = filepath.Walk(patchfilesDirectory, func(path string, f os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		if !f.IsDir() {
-			yamlFile := filepath.Join(buildSrcDirectory, filepath.Base(filepath.Dir(path)))
+			yamlFile := filepath.Join(ctx.Dirs.Src, filepath.Base(filepath.Dir(path)))
 			err := appendFileToFile(path, yamlFile)
 			return err
 		}
@@ -281,60 +807,681 @@ func patchFirmware(v FirmwareVersion) error {
 	}
 
 	// Update kobopatch.yaml with version and overrides.
-	err = updateKobopatchYaml()
+	err = ctx.updateKobopatchYaml(v)
 	if err != nil {
 		return err
 	}
 
 	// Run the kobopatch binary with the generated `kobopatch.yaml` file.
-	cmd := exec.Command(fmt.Sprintf("./bin/%s", buildMap["kobopatch"]))
-	cmd.Dir = buildDirectory
-	cmd.Stdout = os.Stdout
+	host := fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
+	hostBinaries, ok := buildMap[host]
+	if !ok {
+		return errors.Errorf("no kobopatch binary was built for host target %s", host)
+	}
 
-	if err := cmd.Run(); err != nil {
+	kobopatchBin, err := filepath.Abs(filepath.Join(ctx.Dirs.Bin, hostBinaries["kobopatch"]))
+	if err != nil {
 		return err
 	}
 
-	return nil
+	cmd := exec.Command(kobopatchBin)
+	cmd.Dir = ctx.Dirs.Build
+	cmd.Stdout = os.Stdout
+
+	return cmd.Run()
 }
 
-func main() {
-	// Create required directories.
-	requiredDirectories := []string{buildDirectory, buildBinDirectory, buildOutDirectory, buildSrcDirectory}
-	for _, dir := range requiredDirectories {
-		err := os.MkdirAll(dir, os.ModePerm)
-		if err != nil {
-			log.Fatalln(err)
+// ArchiveFile is a single entry to be written into a release archive, with
+// src resolved on disk and dst used as the path inside the archive. Src is
+// unused when IsDir is true.
+type ArchiveFile struct {
+	Src   string
+	Dst   string
+	Perm  os.FileMode
+	IsDir bool
+}
+
+// archiveNameTemplate renders the filename of a per-target release archive,
+// e.g. "kobopatch-4.19.14123-linux-64bit.tar.gz".
+var archiveNameTemplate = template.Must(template.New("archiveName").Parse("kobopatch-{{.Version}}-{{.Target}}{{.Ext}}"))
+
+// ReleaseManifest describes the contents of a single patch release, written
+// alongside the archives as manifest-<version>.json.
+type ReleaseManifest struct {
+	FirmwareVersion string    `json:"firmwareVersion"`
+	UUID            string    `json:"uuid"`
+	Patches         []string  `json:"patches"`
+	GitSHA          string    `json:"gitSha"`
+	BuildTime       time.Time `json:"buildTime"`
+}
+
+// targetByString looks up the Target matching an "os/arch" string, as
+// produced by Target.String() and used as a buildKobopatch map key.
+func targetByString(s string) (Target, bool) {
+	for _, t := range allTargets {
+		if t.String() == s {
+			return t, true
 		}
 	}
 
-	// Read and decode the firmwares file.
-	firmwareFile, err := ioutil.ReadFile("firmwares.json")
-	if err != nil {
-		log.Fatalln(err)
-	}
+	return Target{}, false
+}
 
-	var firmwares []Firmware
-	err = json.Unmarshal(firmwareFile, &firmwares)
+// gitRevision returns the short SHA of the currently checked-out commit.
+func gitRevision() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	out, err := cmd.Output()
 	if err != nil {
-		log.Fatalln(err)
+		return "", errors.Wrap(err, "failed to determine git revision")
 	}
 
-	// Find the desired firmware and patch it.
-	for _, fw := range firmwares {
-		if fw.Id == *uuid {
-			for _, v := range fw.Versions {
-				if v.Version == *version {
-					err := patchFirmware(v)
-					if err != nil {
-						log.Fatalln(err)
-					}
+	return strings.TrimSpace(string(out)), nil
+}
 
-					return
-				}
-			}
+// listPatches returns the names of the patch files concatenated for v, for
+// recording in the release manifest.
+func listPatches(v string) ([]string, error) {
+	patchfilesDirectory := fmt.Sprintf("kobopatch-patches/src/versions/%s", v)
+
+	var patches []string
+	err := filepath.Walk(patchfilesDirectory, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-	}
 
-	log.Fatalln("firmware not found!")
+		if !f.IsDir() {
+			patches = append(patches, filepath.Base(path))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return patches, nil
+}
+
+// collectOutFiles walks outDir (which, after patchFirmware has run, contains
+// the patched firmware image and the KoboRoot.tgz on-device payload) into a
+// flat list of ArchiveFile entries relative to its root.
+func collectOutFiles(outDir string) ([]ArchiveFile, error) {
+	var files []ArchiveFile
+	err := filepath.Walk(outDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(outDir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if f.IsDir() {
+			files = append(files, ArchiveFile{Dst: rel, Perm: f.Mode().Perm(), IsDir: true})
+			return nil
+		}
+
+		files = append(files, ArchiveFile{Src: path, Dst: rel, Perm: 0644})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func writeZipArchive(path string, files []ArchiveFile) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	defer zw.Close()
+
+	for _, f := range files {
+		if f.IsDir {
+			header := &zip.FileHeader{Name: f.Dst + "/"}
+			header.SetMode(f.Perm | os.ModeDir)
+
+			if _, err := zw.CreateHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		src, err := os.Open(f.Src)
+		if err != nil {
+			return err
+		}
+
+		header := &zip.FileHeader{Name: f.Dst, Method: zip.Deflate}
+		header.SetMode(f.Perm)
+
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			src.Close()
+			return err
+		}
+
+		_, err = io.Copy(w, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeTarGzArchive(path string, files []ArchiveFile) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	defer gz.Close()
+
+	tw := tar.NewWriter(gz)
+	defer tw.Close()
+
+	for _, f := range files {
+		if f.IsDir {
+			header := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     f.Dst + "/",
+				Mode:     int64(f.Perm),
+			}
+			if err := tw.WriteHeader(header); err != nil {
+				return err
+			}
+			continue
+		}
+
+		src, err := os.Open(f.Src)
+		if err != nil {
+			return err
+		}
+
+		info, err := src.Stat()
+		if err != nil {
+			src.Close()
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			src.Close()
+			return err
+		}
+		header.Name = f.Dst
+		header.Mode = int64(f.Perm)
+
+		if err := tw.WriteHeader(header); err != nil {
+			src.Close()
+			return err
+		}
+
+		_, err = io.Copy(tw, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeChecksums hashes each of paths and writes a `sha256sum`-compatible
+// checksums file to checksumsPath.
+func writeChecksums(checksumsPath string, paths []string) error {
+	var lines []string
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		lines = append(lines, fmt.Sprintf("%s  %s", hex.EncodeToString(h.Sum(nil)), filepath.Base(p)))
+	}
+
+	return ioutil.WriteFile(checksumsPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// packageRelease bundles outDir (a version's build/<version>/out) and the per-target binaries from
+// buildMap into release archives under build/release, alongside a
+// manifest-<version>.json and a SHA256SUMS-<version> file covering every
+// archive. The version is suffixed onto both filenames so that batch
+// -all runs across multiple firmware versions don't collide.
+func packageRelease(v FirmwareVersion, uuid string, buildMap map[string]map[string]string, outDir string) error {
+	if err := os.MkdirAll(buildReleaseDirectory, os.ModePerm); err != nil {
+		return err
+	}
+
+	outFiles, err := collectOutFiles(outDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to collect build/out files")
+	}
+
+	patches, err := listPatches(v.Version)
+	if err != nil {
+		return errors.Wrap(err, "failed to list patches")
+	}
+
+	gitSHA, err := gitRevision()
+	if err != nil {
+		return err
+	}
+
+	var archivePaths []string
+	for target, binaries := range buildMap {
+		t, ok := targetByString(target)
+		if !ok {
+			return errors.Errorf("unknown target %s", target)
+		}
+
+		ext := ".tar.gz"
+		if t.GOOS == "windows" {
+			ext = ".zip"
+		}
+
+		var nameBuf bytes.Buffer
+		err := archiveNameTemplate.Execute(&nameBuf, struct{ Version, Target, Ext string }{v.Version, t.Suffix, ext})
+		if err != nil {
+			return err
+		}
+		archivePath := filepath.Join(buildReleaseDirectory, nameBuf.String())
+
+		files := append([]ArchiveFile{}, outFiles...)
+		for _, binName := range binaries {
+			files = append(files, ArchiveFile{Src: filepath.Join(buildBinDirectory, binName), Dst: binName, Perm: 0755})
+		}
+
+		if ext == ".zip" {
+			err = writeZipArchive(archivePath, files)
+		} else {
+			err = writeTarGzArchive(archivePath, files)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to write archive for %s", target)
+		}
+
+		archivePaths = append(archivePaths, archivePath)
+	}
+
+	manifest := ReleaseManifest{
+		FirmwareVersion: v.Version,
+		UUID:            uuid,
+		Patches:         patches,
+		GitSHA:          gitSHA,
+		BuildTime:       time.Now(),
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	manifestPath := filepath.Join(buildReleaseDirectory, fmt.Sprintf("manifest-%s.json", v.Version))
+	err = ioutil.WriteFile(manifestPath, manifestBytes, 0644)
+	if err != nil {
+		return err
+	}
+
+	checksumsPath := filepath.Join(buildReleaseDirectory, fmt.Sprintf("SHA256SUMS-%s", v.Version))
+	return writeChecksums(checksumsPath, archivePaths)
+}
+
+// loadFirmwares reads and decodes firmwares.json.
+func loadFirmwares() ([]Firmware, error) {
+	firmwareFile, err := ioutil.ReadFile("firmwares.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var firmwares []Firmware
+	if err := json.Unmarshal(firmwareFile, &firmwares); err != nil {
+		return nil, err
+	}
+
+	return firmwares, nil
+}
+
+// matchingVersions returns the firmware versions for uuid that should be
+// selected: an exact match on version by default, or every version matching
+// the version predicate (range/glob) when all is true.
+func matchingVersions(firmwares []Firmware, uuid, version string, all bool) ([]FirmwareVersion, error) {
+	if !all {
+		for _, fw := range firmwares {
+			if fw.Id != uuid {
+				continue
+			}
+
+			for _, v := range fw.Versions {
+				if v.Version == version {
+					return []FirmwareVersion{v}, nil
+				}
+			}
+		}
+
+		return nil, nil
+	}
+
+	predicate := parseVersionPredicate(version)
+
+	var matches []FirmwareVersion
+	for _, fw := range firmwares {
+		if fw.Id != uuid {
+			continue
+		}
+
+		for _, v := range fw.Versions {
+			ok, err := predicate.Match(v.Version)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matches = append(matches, v)
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// runParallel calls fn for every version, running up to jobs calls
+// concurrently, and returns the first error once every call has finished.
+func runParallel(versions []FirmwareVersion, jobs int, fn func(FirmwareVersion) error) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	for _, v := range versions {
+		v := v
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(v); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrapf(err, "%s", v.Version)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "fetch":
+		cmdFetch(args)
+	case "build":
+		cmdBuild(args)
+	case "patch":
+		cmdPatch(args)
+	case "clean":
+		cmdClean(args)
+	case "list":
+		cmdList(args)
+	case "-h", "-help", "--help", "help":
+		printUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `Usage: %s <command> [options]
+
+Commands:
+  fetch   download firmware only
+  build   cross-compile the kobopatch tools only
+  patch   fetch, build and patch firmware (default end-to-end behaviour)
+  clean   remove the build directory
+  list    print firmwares.json entries matching -uuid/-version
+
+Run "%s <command> -h" to see a command's options.
+`, os.Args[0], os.Args[0])
+}
+
+// cmdFetch downloads the firmware(s) matching -uuid/-version (or every
+// version matching -version when -all is given) into the firmware cache.
+func cmdFetch(args []string) {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	versionFlag := fs.String("version", "4.19.14123", "version of the firmware to fetch")
+	uuidFlag := fs.String("uuid", "00000000-0000-0000-0000-000000000370", "uuid of the kobo (see firmwares.json)")
+	allFlag := fs.Bool("all", false, "fetch every firmware version matching -version instead of requiring an exact match")
+	jobsFlag := fs.Int("jobs", 1, "number of firmware versions to fetch in parallel (only useful with -all)")
+	noCacheFlag := fs.Bool("no-cache", false, "don't use the firmware download cache, always download directly")
+	refreshFlag := fs.Bool("refresh", false, "ignore any cached firmware download and re-fetch it")
+	fs.Parse(args)
+
+	firmwares, err := loadFirmwares()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	matches, err := matchingVersions(firmwares, *uuidFlag, *versionFlag, *allFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(matches) == 0 {
+		log.Fatalln("firmware not found!")
+	}
+
+	if err := os.MkdirAll(buildDirectory, os.ModePerm); err != nil {
+		log.Fatalln(err)
+	}
+
+	err = runParallel(matches, *jobsFlag, func(v FirmwareVersion) error {
+		ctx := newBuildContext(v.Version, *uuidFlag)
+		if err := os.MkdirAll(ctx.Dirs.Src, os.ModePerm); err != nil {
+			return err
+		}
+
+		_, err := ctx.downloadFirmware(v, *noCacheFlag, *refreshFlag)
+		return err
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// cmdBuild cross-compiles the kobopatch tools for -targets/-include/-exclude
+// without patching any firmware.
+func cmdBuild(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	targetsFlag := fs.String("targets", "", "comma-separated list of os/arch targets to build (default: host only)")
+	includeFlag := fs.String("include", "", "only build targets whose os/arch matches this regexp")
+	excludeFlag := fs.String("exclude", "", "skip targets whose os/arch matches this regexp")
+	parallelFlag := fs.Int("parallel", runtime.NumCPU(), "number of `go build` invocations to run concurrently")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(buildBinDirectory, os.ModePerm); err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := newBuildContext("", "")
+	buildMap, err := ctx.buildKobopatch(BuildOptions{
+		Targets:  *targetsFlag,
+		Include:  *includeFlag,
+		Exclude:  *excludeFlag,
+		Parallel: *parallelFlag,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for target, binaries := range buildMap {
+		for pkg, name := range binaries {
+			fmt.Printf("%s\t%s\t%s\n", target, pkg, name)
+		}
+	}
+}
+
+// cmdPatch runs the full fetch+build+patch pipeline, optionally across every
+// firmware version matching -version (-all) and optionally packaging the
+// result (-package). The kobopatch tools are built once and reused across
+// every version patched in this invocation.
+func cmdPatch(args []string) {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	versionFlag := fs.String("version", "4.19.14123", "version of the patch to create")
+	uuidFlag := fs.String("uuid", "00000000-0000-0000-0000-000000000370", "uuid of the kobo (see firmwares.json)")
+	allFlag := fs.Bool("all", false, "patch every firmware version matching -version instead of requiring an exact match")
+	jobsFlag := fs.Int("jobs", 1, "number of firmware versions to patch in parallel (only useful with -all)")
+	targetsFlag := fs.String("targets", "", "comma-separated list of os/arch targets to build (default: host only)")
+	includeFlag := fs.String("include", "", "only build targets whose os/arch matches this regexp")
+	excludeFlag := fs.String("exclude", "", "skip targets whose os/arch matches this regexp")
+	parallelFlag := fs.Int("parallel", runtime.NumCPU(), "number of `go build` invocations to run concurrently")
+	packageFlag := fs.Bool("package", false, "after patching, bundle build/<version>/out and the cross-compiled binaries into release archives")
+	noCacheFlag := fs.Bool("no-cache", false, "don't use the firmware download cache, always download directly")
+	refreshFlag := fs.Bool("refresh", false, "ignore any cached firmware download and re-fetch it")
+	fs.Parse(args)
+
+	if err := os.MkdirAll(buildBinDirectory, os.ModePerm); err != nil {
+		log.Fatalln(err)
+	}
+
+	firmwares, err := loadFirmwares()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	matches, err := matchingVersions(firmwares, *uuidFlag, *versionFlag, *allFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(matches) == 0 {
+		log.Fatalln("firmware not found!")
+	}
+
+	opts := BuildOptions{
+		Targets:  *targetsFlag,
+		Include:  *includeFlag,
+		Exclude:  *excludeFlag,
+		Parallel: *parallelFlag,
+	}
+
+	var buildOnce sync.Once
+	var buildMap map[string]map[string]string
+	var buildErr error
+
+	err = runParallel(matches, *jobsFlag, func(v FirmwareVersion) error {
+		ctx := newBuildContext(v.Version, *uuidFlag)
+		for _, dir := range []string{ctx.Dirs.Build, ctx.Dirs.Src, ctx.Dirs.Out} {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				return err
+			}
+		}
+
+		buildOnce.Do(func() {
+			buildMap, buildErr = ctx.buildKobopatch(opts)
+		})
+		if buildErr != nil {
+			return buildErr
+		}
+
+		if err := ctx.patchFirmware(v, buildMap, *noCacheFlag, *refreshFlag); err != nil {
+			return err
+		}
+
+		if *packageFlag {
+			if err := packageRelease(v, *uuidFlag, buildMap, ctx.Dirs.Out); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// cmdClean removes the build directory.
+func cmdClean(args []string) {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	fs.Parse(args)
+
+	if err := os.RemoveAll(buildDirectory); err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Println("Removed " + buildDirectory)
+}
+
+// cmdList prints the firmwares.json entries matching -uuid/-version (both
+// optional; omitted means "any").
+func cmdList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	uuidFlag := fs.String("uuid", "", "only list firmwares with this uuid (default: all)")
+	versionFlag := fs.String("version", "", "only list versions matching this selector, e.g. '>=4.19.0' or '4.3*.*' (default: all)")
+	fs.Parse(args)
+
+	firmwares, err := loadFirmwares()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var predicate *versionPredicate
+	if *versionFlag != "" {
+		p := parseVersionPredicate(*versionFlag)
+		predicate = &p
+	}
+
+	for _, fw := range firmwares {
+		if *uuidFlag != "" && fw.Id != *uuidFlag {
+			continue
+		}
+
+		for _, v := range fw.Versions {
+			if predicate != nil {
+				ok, err := predicate.Match(v.Version)
+				if err != nil {
+					log.Fatalln(err)
+				}
+				if !ok {
+					continue
+				}
+			}
+
+			fmt.Printf("%s\t%s\t%s\t%s\n", fw.Id, fw.Model, v.Version, v.Date)
+		}
+	}
 }
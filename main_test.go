@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"4.19.14123", "4.19.14123", 0},
+		{"4.19.14123", "4.19.14124", -1},
+		{"4.19.14124", "4.19.14123", 1},
+		{"4.3.0", "4.19.0", -1},
+		{"4.19", "4.19.0", -1},
+		{"beta", "beta", 0},
+	}
+
+	for _, c := range cases {
+		if got := compareVersions(c.a, c.b); sign(got) != sign(c.want) {
+			t.Errorf("compareVersions(%q, %q) = %d, want sign %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func TestVersionPredicateMatch(t *testing.T) {
+	cases := []struct {
+		expr, v string
+		want    bool
+	}{
+		{">=4.19.14123", "4.19.14123", true},
+		{">=4.19.14123", "4.19.14122", false},
+		{"<4.19.14123", "4.3.0", true},
+		{"4.3*.*", "4.3.14", true},
+		{"4.3*.*", "4.4.14", false},
+		{"4.19.14123", "4.19.14123", true},
+	}
+
+	for _, c := range cases {
+		p := parseVersionPredicate(c.expr)
+		got, err := p.Match(c.v)
+		if err != nil {
+			t.Fatalf("Match(%q) against %q: %v", c.expr, c.v, err)
+		}
+		if got != c.want {
+			t.Errorf("%q.Match(%q) = %v, want %v", c.expr, c.v, got, c.want)
+		}
+	}
+}
+
+// serveContent starts a test server serving body with Range support, to
+// exercise firmwareCache's resume path.
+func serveContent(t *testing.T, body []byte) *httptest.Server {
+	t.Helper()
+	modTime := time.Unix(0, 0)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "firmware.zip", modTime, bytes.NewReader(body))
+	}))
+}
+
+func TestFirmwareCacheDownloadResumesFromPart(t *testing.T) {
+	body := []byte("this is totally a firmware image")
+	srv := serveContent(t, body)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kobo-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &firmwareCache{dir: dir}
+	logger := log.New(ioutil.Discard, "", 0)
+	sum := sha256.Sum256(body)
+	v := FirmwareVersion{Download: srv.URL, Version: "1.0.0", Sha256: hex.EncodeToString(sum[:])}
+
+	final := c.path("uuid", v.Version)
+	part := final + ".part"
+	if err := ioutil.WriteFile(part, body[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.download(srv.Client(), logger, final, v); err != nil {
+		t.Fatalf("download() error: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(final)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("resumed download = %q, want %q", got, body)
+	}
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Errorf(".part file should be renamed away after a successful download")
+	}
+}
+
+func TestFirmwareCacheDownloadDiscardsPartOnChecksumMismatch(t *testing.T) {
+	body := []byte("this is totally a firmware image")
+	srv := serveContent(t, body)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kobo-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &firmwareCache{dir: dir}
+	logger := log.New(ioutil.Discard, "", 0)
+	v := FirmwareVersion{Download: srv.URL, Version: "1.0.0", Sha256: strings.Repeat("0", 64)}
+
+	final := c.path("uuid", v.Version)
+	part := final + ".part"
+
+	if err := c.download(srv.Client(), logger, final, v); err == nil {
+		t.Fatal("expected a checksum mismatch error")
+	}
+
+	if _, err := os.Stat(part); !os.IsNotExist(err) {
+		t.Errorf(".part file should be discarded after a checksum mismatch, got stat err %v", err)
+	}
+}
+
+func TestFirmwareCacheDownloadRejectsErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kobo-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &firmwareCache{dir: dir}
+	logger := log.New(ioutil.Discard, "", 0)
+	v := FirmwareVersion{Download: srv.URL, Version: "1.0.0"}
+
+	final := c.path("uuid", v.Version)
+	if err := c.download(srv.Client(), logger, final, v); err == nil {
+		t.Fatal("expected an error for a non-200/206 response")
+	}
+
+	if _, err := os.Stat(final); !os.IsNotExist(err) {
+		t.Errorf("a 404 response should not be cached as firmware, got stat err %v", err)
+	}
+	if _, err := os.Stat(final + ".part"); !os.IsNotExist(err) {
+		t.Errorf("a 404 response should not be left behind as a .part file, got stat err %v", err)
+	}
+}
+
+func TestFirmwareCacheFetchRefreshDiscardsStalePart(t *testing.T) {
+	body := []byte("this is totally a firmware image")
+	srv := serveContent(t, body)
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "kobo-cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := &firmwareCache{dir: dir}
+	logger := log.New(ioutil.Discard, "", 0)
+	sum := sha256.Sum256(body)
+	v := FirmwareVersion{Download: srv.URL, Version: "1.0.0", Sha256: hex.EncodeToString(sum[:])}
+
+	final := c.path("uuid", v.Version)
+	part := final + ".part"
+	// Simulate stale bytes left behind by an unrelated, corrupt prior attempt.
+	if err := ioutil.WriteFile(part, []byte("garbage that does not belong"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Fetch(srv.Client(), logger, "uuid", v, true)
+	if err != nil {
+		t.Fatalf("Fetch() error: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(body) {
+		t.Errorf("Fetch() with refresh = %q, want a clean re-download of %q", data, body)
+	}
+}